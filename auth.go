@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/time/rate"
+)
+
+const (
+	roleAdmin  = "admin"
+	roleViewer = "viewer"
+)
+
+const (
+	usersFilePath        = "users.json"
+	tokenTTL             = 24 * time.Hour
+	adminWritesPerMinute = 30
+	wsInboundPerSecond   = 10
+)
+
+// Claims adalah klaim JWT kustom yang membawa peran pengguna selain
+// klaim standar (subjek, kedaluwarsa, dst).
+type Claims struct {
+	Role string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+// User adalah satu baris pada berkas konfigurasi pengguna lokal
+// (usersFilePath) yang dipakai /api/login untuk pengembangan.
+type User struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+// claimsContextKey adalah kunci context.Context tempat Claims yang sudah
+// tervalidasi disimpan oleh Auth.Middleware.
+type claimsContextKey struct{}
+
+// Auth memvalidasi dan menerbitkan JWT HS256, serta membatasi laju
+// permintaan tulis per subjek token.
+type Auth struct {
+	secret       []byte
+	users        []User
+	writeLimiter *RateLimiter
+}
+
+// NewAuth membaca daftar pengguna lokal (jika ada) dan menyiapkan
+// pembatas laju penulisan admin. secret kosong dianggap fatal: dengan
+// HS256, secret kosong berarti setiap token yang ditandatangani dengan
+// kunci kosong akan tervalidasi, bukan ditolak, sehingga siapapun bisa
+// memalsukan token admin.
+func NewAuth(secret []byte) (*Auth, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("JWT_SECRET belum diset")
+	}
+
+	users, err := loadUsers(usersFilePath)
+	if err != nil {
+		log.Printf("gagal memuat %s, /api/login tidak akan tersedia: %v", usersFilePath, err)
+	}
+
+	return &Auth{
+		secret:       secret,
+		users:        users,
+		writeLimiter: NewRateLimiter(rate.Limit(float64(adminWritesPerMinute)/60), adminWritesPerMinute),
+	}, nil
+}
+
+func loadUsers(path string) ([]User, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var users []User
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, fmt.Errorf("unmarshal %s: %w", path, err)
+	}
+	return users, nil
+}
+
+// mintToken membuat JWT HS256 yang berlaku selama tokenTTL.
+func (a *Auth) mintToken(subject, role string) (string, error) {
+	claims := Claims{
+		Role: role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(tokenTTL)),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(a.secret)
+}
+
+// parseToken memvalidasi sebuah JWT dan mengembalikan klaimnya.
+func (a *Auth) parseToken(raw string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("metode signing tidak didukung: %v", t.Header["alg"])
+		}
+		return a.secret, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("token tidak valid")
+	}
+	return claims, nil
+}
+
+// tokenFromRequest mengambil token dari header Authorization: Bearer ...
+// atau, untuk koneksi WebSocket yang sulit mengirim header kustom, dari
+// query string ?token=.
+func tokenFromRequest(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.URL.Query().Get("token")
+}
+
+// Authenticate memvalidasi token pada request, menerima peran admin
+// maupun viewer.
+func (a *Auth) Authenticate(r *http.Request) (*Claims, error) {
+	return a.parseToken(tokenFromRequest(r))
+}
+
+// Middleware memvalidasi token (admin atau viewer) dan menyimpan
+// klaimnya di context sebelum meneruskan ke next.
+func (a *Auth) Middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := a.Authenticate(r)
+		if err != nil {
+			http.Error(w, "token tidak valid", http.StatusUnauthorized)
+			return
+		}
+		ctx := context.WithValue(r.Context(), claimsContextKey{}, claims)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// RequireAdmin memvalidasi token, menolak peran non-admin dengan 403, dan
+// menegakkan batas laju penulisan per subjek token.
+func (a *Auth) RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return a.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		claims := claimsFromContext(r.Context())
+		if claims.Role != roleAdmin {
+			http.Error(w, "hanya admin yang diizinkan", http.StatusForbidden)
+			return
+		}
+		if !a.writeLimiter.Allow(claims.Subject) {
+			http.Error(w, "batas laju tercapai, coba lagi nanti", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	})
+}
+
+func claimsFromContext(ctx context.Context) *Claims {
+	claims, _ := ctx.Value(claimsContextKey{}).(*Claims)
+	return claims
+}
+
+// loginRequest adalah body yang diterima /api/login.
+type loginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// loginResponse membawa token JWT yang baru diterbitkan.
+type loginResponse struct {
+	Token string `json:"token"`
+	Role  string `json:"role"`
+}
+
+// handleLogin menerbitkan token dari daftar pengguna di usersFilePath,
+// dipakai untuk pengembangan lokal.
+func (a *Auth) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Metode tidak diizinkan", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req loginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Request body tidak valid", http.StatusBadRequest)
+		return
+	}
+
+	for _, u := range a.users {
+		if u.Username != req.Username || u.Password != req.Password {
+			continue
+		}
+		token, err := a.mintToken(u.Username, u.Role)
+		if err != nil {
+			http.Error(w, "gagal menerbitkan token", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(loginResponse{Token: token, Role: u.Role})
+		return
+	}
+
+	http.Error(w, "username atau password salah", http.StatusUnauthorized)
+}
+
+// rateLimiterIdleTTL adalah lama seorang subjek boleh tidak aktif sebelum
+// limiternya dibuang oleh RateLimiter.evictLoop, agar limiters tidak
+// tumbuh tanpa batas seiring token baru terus diterbitkan.
+const rateLimiterIdleTTL = 10 * time.Minute
+
+// RateLimiter menjaga satu token-bucket per subjek token JWT, sehingga
+// batas laju diterapkan per pengguna, bukan global.
+type RateLimiter struct {
+	mu       sync.Mutex
+	limiters map[string]*rateLimiterEntry
+	rps      rate.Limit
+	burst    int
+}
+
+type rateLimiterEntry struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+func NewRateLimiter(rps rate.Limit, burst int) *RateLimiter {
+	rl := &RateLimiter{
+		limiters: make(map[string]*rateLimiterEntry),
+		rps:      rps,
+		burst:    burst,
+	}
+	go rl.evictLoop()
+	return rl
+}
+
+func (rl *RateLimiter) Allow(subject string) bool {
+	rl.mu.Lock()
+	entry, ok := rl.limiters[subject]
+	if !ok {
+		entry = &rateLimiterEntry{limiter: rate.NewLimiter(rl.rps, rl.burst)}
+		rl.limiters[subject] = entry
+	}
+	entry.lastUsed = time.Now()
+	limiter := entry.limiter
+	rl.mu.Unlock()
+
+	return limiter.Allow()
+}
+
+// evictLoop membuang limiter subjek yang sudah lama tidak dipakai, agar
+// map limiters tidak tumbuh tanpa batas seiring banyaknya token yang
+// pernah diterbitkan.
+func (rl *RateLimiter) evictLoop() {
+	ticker := time.NewTicker(rateLimiterIdleTTL)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		cutoff := time.Now().Add(-rateLimiterIdleTTL)
+		rl.mu.Lock()
+		for subject, entry := range rl.limiters {
+			if entry.lastUsed.Before(cutoff) {
+				delete(rl.limiters, subject)
+			}
+		}
+		rl.mu.Unlock()
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/nats-io/nats.go"
+)
+
+// jetStreamSubjectPrefix awalan subjek NATS untuk setiap jenis kejadian,
+// menghasilkan subjek tahan lama seperti stock.CREATE, stock.UPDATE dan
+// stock.DELETE untuk dikonsumsi instance server lain maupun pihak luar.
+const jetStreamSubjectPrefix = "stock."
+
+// BrokerEvent membungkus WebSocketMessage dengan OriginID, ID unik hub
+// yang mempublikasikannya. Setiap hub juga menerima kembali pesannya
+// sendiri lewat Subscribe (broker tidak tahu soal pengirim asli), jadi
+// OriginID dipakai Hub.run untuk membedakan echo kejadian sendiri (sudah
+// diterapkan+dicatat) dari kejadian instance lain (lihat Hub.originID).
+type BrokerEvent struct {
+	OriginID string
+	Msg      WebSocketMessage
+}
+
+// Broker menyebarkan BrokerEvent antar instance server, sehingga beberapa
+// proses main di belakang load balancer tetap mengirimkan setiap
+// perubahan stok ke seluruh klien yang tersambung, di instance manapun.
+type Broker interface {
+	Publish(ev BrokerEvent) error
+	Subscribe(ctx context.Context) (<-chan BrokerEvent, error)
+}
+
+// NewBrokerFromEnv memilih implementasi Broker lewat env var BROKER_URL:
+// kosong berarti LocalBroker (satu proses), selain itu dianggap alamat
+// server NATS JetStream.
+func NewBrokerFromEnv() (Broker, error) {
+	if url := os.Getenv("BROKER_URL"); url != "" {
+		return NewJetStreamBroker(url)
+	}
+	return NewLocalBroker(), nil
+}
+
+// LocalBroker menyebarkan pesan lewat channel in-process. Ini adalah
+// perilaku bawaan saat hanya satu instance server yang berjalan.
+type LocalBroker struct {
+	ch chan BrokerEvent
+}
+
+func NewLocalBroker() *LocalBroker {
+	return &LocalBroker{ch: make(chan BrokerEvent, 64)}
+}
+
+func (b *LocalBroker) Publish(ev BrokerEvent) error {
+	b.ch <- ev
+	return nil
+}
+
+func (b *LocalBroker) Subscribe(ctx context.Context) (<-chan BrokerEvent, error) {
+	return b.ch, nil
+}
+
+// JetStreamBroker menyebarkan pesan lewat NATS JetStream, sehingga
+// beberapa instance server di belakang load balancer menerima setiap
+// perubahan stok secara tahan lama.
+type JetStreamBroker struct {
+	nc *nats.Conn
+	js nats.JetStreamContext
+}
+
+func NewJetStreamBroker(url string) (*JetStreamBroker, error) {
+	nc, err := nats.Connect(url)
+	if err != nil {
+		return nil, fmt.Errorf("konek ke NATS: %w", err)
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("membuat JetStream context: %w", err)
+	}
+
+	if _, err := js.AddStream(&nats.StreamConfig{
+		Name:     "STOCKS",
+		Subjects: []string{jetStreamSubjectPrefix + ">"},
+	}); err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("membuat stream STOCKS: %w", err)
+	}
+
+	return &JetStreamBroker{nc: nc, js: js}, nil
+}
+
+func (b *JetStreamBroker) Publish(ev BrokerEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("marshal pesan broker: %w", err)
+	}
+	if _, err := b.js.Publish(jetStreamSubjectPrefix+ev.Msg.Type, data); err != nil {
+		return fmt.Errorf("publish ke JetStream: %w", err)
+	}
+	return nil
+}
+
+func (b *JetStreamBroker) Subscribe(ctx context.Context) (<-chan BrokerEvent, error) {
+	out := make(chan BrokerEvent, 64)
+
+	// DeliverNew: tanpa ini, consumer efemeral default JetStream memutar
+	// ulang seluruh riwayat stream pada setiap Subscribe (mis. setelah
+	// restart), membanjiri klien yang sedang tersambung dengan siaran
+	// kejadian lama. Replay riwayat sudah ditangani lewat event log lokal
+	// (lihat Hub.persistForeign), jadi broker hanya perlu kejadian baru.
+	sub, err := b.js.Subscribe(jetStreamSubjectPrefix+">", func(m *nats.Msg) {
+		var ev BrokerEvent
+		if err := json.Unmarshal(m.Data, &ev); err != nil {
+			log.Printf("error unmarshal pesan broker: %v", err)
+			return
+		}
+		out <- ev
+	}, nats.DeliverNew())
+	if err != nil {
+		return nil, fmt.Errorf("subscribe ke %s>: %w", jetStreamSubjectPrefix, err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		sub.Unsubscribe()
+		close(out)
+	}()
+
+	return out, nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+	"golang.org/x/time/rate"
+)
+
+// binaryProtocol adalah nilai Sec-WebSocket-Protocol yang harus diminta
+// klien untuk mengaktifkan framing biner (length-prefixed msgpack),
+// dipakai viewer mobile yang sensitif terhadap bandwidth.
+const binaryProtocol = "stock.msgpack.v1"
+
+const (
+	sendBufferSize = 32
+	writeWait      = 10 * time.Second
+	pongWait       = 60 * time.Second
+	pingPeriod     = (pongWait * 9) / 10
+)
+
+// Client menjembatani satu koneksi WebSocket dengan Hub. Setiap Client
+// punya buffer send sendiri dan satu goroutine write-pump khusus, agar
+// satu klien yang lambat tidak memblokir klien lain maupun penulis di
+// handleStock (lihat writePump/readPump).
+type Client struct {
+	hub     *Hub
+	conn    *websocket.Conn
+	send    chan any
+	subject string
+	binary  bool
+
+	// mu menjaga subscriptions dan closed. send hanya boleh ditutup lewat
+	// close(), dan hanya boleh dikirimi lewat enqueue(), keduanya di bawah
+	// mu, agar tidak pernah terjadi "send on closed channel".
+	mu            sync.Mutex
+	subscriptions map[string]bool
+	closed        bool
+}
+
+func newClient(hub *Hub, conn *websocket.Conn, subject string, binary bool) *Client {
+	return &Client{
+		hub:           hub,
+		conn:          conn,
+		send:          make(chan any, sendBufferSize),
+		subject:       subject,
+		binary:        binary,
+		subscriptions: make(map[string]bool),
+	}
+}
+
+// subscribe menambahkan topics ke daftar langganan klien ini.
+func (c *Client) subscribe(topics []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, topic := range topics {
+		c.subscriptions[topic] = true
+	}
+}
+
+// unsubscribe menghapus topics dari daftar langganan klien ini.
+func (c *Client) unsubscribe(topics []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, topic := range topics {
+		delete(c.subscriptions, topic)
+	}
+}
+
+// matches melaporkan apakah klien berlangganan salah satu dari topics.
+func (c *Client) matches(topics []string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return matchesTopics(c.subscriptions, topics)
+}
+
+// enqueue menitipkan payload ke buffer send klien tanpa memblokir. Jika
+// buffer penuh, klien dianggap terlalu lambat dan diputus alih-alih
+// membiarkan satu klien menahan seluruh hub. Pengiriman dan penutupan
+// send digabung di bawah mu yang sama sehingga tidak pernah terjadi
+// "send on closed channel", dan hub.removeClient dipanggil di luar
+// bagian yang mengunci mu Client (removeClient mengunci h.mu sendiri).
+func (c *Client) enqueue(payload any) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+
+	select {
+	case c.send <- payload:
+		c.mu.Unlock()
+	default:
+		c.closed = true
+		close(c.send)
+		c.mu.Unlock()
+		log.Printf("klien %s lambat menerima pesan, memutus koneksi", c.subject)
+		c.hub.removeClient(c)
+	}
+}
+
+// close menutup buffer send klien, sekali saja. Aman dipanggil bersamaan
+// dengan enqueue dari goroutine lain.
+func (c *Client) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.send)
+}
+
+// writePump adalah satu-satunya goroutine yang menulis ke koneksi,
+// memutus muatan dari send channel dan mengirim ping keepalive berkala.
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case payload, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.writeMessage(payload); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// writeMessage menulis payload sebagai JSON teks, atau sebagai bingkai
+// biner msgpack diawali panjang 4 byte jika klien menegosiasikan
+// binaryProtocol lewat Sec-WebSocket-Protocol.
+func (c *Client) writeMessage(payload any) error {
+	if !c.binary {
+		return c.conn.WriteJSON(payload)
+	}
+
+	data, err := msgpack.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+
+	return c.conn.WriteMessage(websocket.BinaryMessage, frame)
+}
+
+// readPump menerima pesan kendali dari klien (SUBSCRIBE/UNSUBSCRIBE) dan
+// menjaga keepalive lewat deadline baca yang direset setiap pong.
+func (c *Client) readPump() {
+	defer func() {
+		c.hub.removeClient(c)
+		c.conn.Close()
+		log.Printf("Klien terputus: %s", c.subject)
+	}()
+
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+
+	inbound := rate.NewLimiter(wsInboundPerSecond, wsInboundPerSecond)
+
+	for {
+		var cm ClientMessage
+		if err := c.conn.ReadJSON(&cm); err != nil {
+			break
+		}
+
+		if !inbound.Allow() {
+			continue
+		}
+
+		switch cm.Type {
+		case "SUBSCRIBE":
+			// topics kosong berarti klien hanya minta replay lewat
+			// last_seq (kontrak chunk0-1) tanpa menyaring topik apapun,
+			// jadi diperlakukan sebagai wildcard alih-alih tidak
+			// berlangganan apapun.
+			topics := cm.Topics
+			if len(topics) == 0 {
+				topics = []string{topicWildcard}
+			}
+			c.subscribe(topics)
+			if err := c.hub.sendSnapshotAndReplay(c, cm.LastSeq); err != nil {
+				log.Printf("error mengirim snapshot/replay: %v", err)
+			}
+		case "UNSUBSCRIBE":
+			c.unsubscribe(cm.Topics)
+		}
+	}
+}
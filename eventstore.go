@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Event adalah satu entri pada log kejadian yang bersifat append-only.
+// Seq naik secara monoton dan menjadi acuan urutan replay bagi klien.
+type Event struct {
+	Seq     uint64 `json:"seq"`
+	Type    string `json:"type"`
+	Payload Stock  `json:"payload"`
+}
+
+// snapshotFile adalah bentuk serialisasi dari snapshot periodik: keadaan
+// stok lengkap pada saat Seq tertentu, agar replay tidak perlu kembali
+// ke awal waktu.
+type snapshotFile struct {
+	Seq    uint64           `json:"seq"`
+	Stocks map[string]Stock `json:"stocks"`
+}
+
+// EventStore menyimpan setiap perubahan stok ke berkas NDJSON agar dapat
+// diputar ulang (replay) ketika klien menyambung kembali setelah putus
+// jaringan atau restart server.
+type EventStore struct {
+	mu      sync.Mutex
+	file    *os.File
+	path    string
+	lastSeq uint64
+}
+
+// NewEventStore membuka (atau membuat) berkas log pada path dan memuat
+// lastSeq dari kejadian yang sudah tersimpan di dalamnya.
+func NewEventStore(path string) (*EventStore, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("membuka event log: %w", err)
+	}
+
+	es := &EventStore{file: f, path: path}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var ev Event
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			continue
+		}
+		if ev.Seq > es.lastSeq {
+			es.lastSeq = ev.Seq
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("membaca event log: %w", err)
+	}
+
+	// Log bisa sudah dipampatkan oleh WriteSnapshot; seq snapshot menjadi
+	// batas bawah agar seq tidak mulai ulang dari awal setelah restart.
+	snapSeq, _, err := es.LoadSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	if snapSeq > es.lastSeq {
+		es.lastSeq = snapSeq
+	}
+
+	return es, nil
+}
+
+// Append menuliskan kejadian baru dengan nomor urut berikutnya. Aman
+// dipanggil dari beberapa goroutine sekaligus (dilindungi es.mu); urutan
+// seq mengikuti urutan pemanggil berhasil mengunci mu, bukan urutan
+// pemanggilan (lihat Hub.mutateAndPublish, Hub.persistForeign).
+func (es *EventStore) Append(msgType string, payload Stock) (Event, error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	es.lastSeq++
+	ev := Event{Seq: es.lastSeq, Type: msgType, Payload: payload}
+
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return Event{}, fmt.Errorf("marshal event: %w", err)
+	}
+	if _, err := es.file.Write(append(line, '\n')); err != nil {
+		return Event{}, fmt.Errorf("menulis event log: %w", err)
+	}
+	if err := es.file.Sync(); err != nil {
+		return Event{}, fmt.Errorf("sync event log: %w", err)
+	}
+
+	return ev, nil
+}
+
+// Replay memanggil fn untuk setiap kejadian dengan Seq > after, secara
+// berurutan.
+func (es *EventStore) Replay(after uint64, fn func(Event) error) error {
+	es.mu.Lock()
+	f, err := os.Open(es.path)
+	es.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("membuka event log untuk replay: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var ev Event
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			continue
+		}
+		if ev.Seq <= after {
+			continue
+		}
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// LastSeq mengembalikan nomor urut kejadian terakhir yang tersimpan.
+func (es *EventStore) LastSeq() uint64 {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	return es.lastSeq
+}
+
+func (es *EventStore) snapshotPath() string {
+	return es.path + ".snapshot"
+}
+
+// WriteSnapshot menuliskan keadaan stok pada seq tertentu ke berkas
+// snapshot. Event log hanya dipampatkan (dikosongkan) jika seq sama
+// dengan lastSeq, karena seluruh kejadian di log lalu sudah tercakup
+// snapshot; jika seq lebih lama, log dibiarkan utuh. seq dan stocks harus
+// berasal dari pasangan yang konsisten (lihat Hub.snapshot) — jika
+// stocks belum mencerminkan kejadian sampai dengan seq ini, kejadian
+// tersebut akan hilang saat dipampatkan.
+func (es *EventStore) WriteSnapshot(seq uint64, stocks map[string]Stock) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	if seq > es.lastSeq {
+		return fmt.Errorf("seq snapshot %d melebihi lastSeq %d", seq, es.lastSeq)
+	}
+
+	data, err := json.Marshal(snapshotFile{Seq: seq, Stocks: stocks})
+	if err != nil {
+		return fmt.Errorf("marshal snapshot: %w", err)
+	}
+
+	tmp := es.snapshotPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("menulis snapshot sementara: %w", err)
+	}
+	if err := os.Rename(tmp, es.snapshotPath()); err != nil {
+		return fmt.Errorf("mengganti nama snapshot: %w", err)
+	}
+
+	if seq == es.lastSeq {
+		if err := es.file.Truncate(0); err != nil {
+			return fmt.Errorf("memampatkan event log: %w", err)
+		}
+		if _, err := es.file.Seek(0, 0); err != nil {
+			return fmt.Errorf("reset posisi event log: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// LoadSnapshot membaca snapshot terakhir, jika ada. Tidak adanya berkas
+// snapshot bukan error: server baru mulai dengan log kosong.
+func (es *EventStore) LoadSnapshot() (uint64, map[string]Stock, error) {
+	data, err := os.ReadFile(es.snapshotPath())
+	if os.IsNotExist(err) {
+		return 0, nil, nil
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("membaca snapshot: %w", err)
+	}
+
+	var snap snapshotFile
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return 0, nil, fmt.Errorf("unmarshal snapshot: %w", err)
+	}
+
+	return snap.Seq, snap.Stocks, nil
+}
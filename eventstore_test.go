@@ -0,0 +1,89 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestEventStoreAppendAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	es, err := NewEventStore(path)
+	if err != nil {
+		t.Fatalf("NewEventStore: %v", err)
+	}
+
+	if _, err := es.Append("CREATE", Stock{ID: "1", Item: "Kopi"}); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if _, err := es.Append("UPDATE", Stock{ID: "1", Item: "Kopi", Quantity: 3}); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+
+	var seen []Event
+	if err := es.Replay(0, func(ev Event) error {
+		seen = append(seen, ev)
+		return nil
+	}); err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if len(seen) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(seen))
+	}
+
+	seen = nil
+	if err := es.Replay(1, func(ev Event) error {
+		seen = append(seen, ev)
+		return nil
+	}); err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if len(seen) != 1 || seen[0].Type != "UPDATE" {
+		t.Fatalf("expected only the UPDATE event after seq 1, got %+v", seen)
+	}
+}
+
+func TestWriteSnapshotKeepsNewerEventsWhenSeqIsStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	es, err := NewEventStore(path)
+	if err != nil {
+		t.Fatalf("NewEventStore: %v", err)
+	}
+
+	first, err := es.Append("CREATE", Stock{ID: "1", Item: "Kopi"})
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if _, err := es.Append("UPDATE", Stock{ID: "1", Item: "Kopi", Quantity: 2}); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+
+	// Simulasikan snapshot yang diambil sebelum event kedua tercatat
+	// (lastSeq sudah maju saat WriteSnapshot benar-benar dipanggil).
+	stale := map[string]Stock{"1": {ID: "1", Item: "Kopi"}}
+	if err := es.WriteSnapshot(first.Seq, stale); err != nil {
+		t.Fatalf("WriteSnapshot: %v", err)
+	}
+
+	var seen []Event
+	if err := es.Replay(first.Seq, func(ev Event) error {
+		seen = append(seen, ev)
+		return nil
+	}); err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if len(seen) != 1 || seen[0].Type != "UPDATE" {
+		t.Fatalf("expected the UPDATE event to survive the stale snapshot, got %+v", seen)
+	}
+}
+
+func TestWriteSnapshotRejectsSeqAheadOfLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	es, err := NewEventStore(path)
+	if err != nil {
+		t.Fatalf("NewEventStore: %v", err)
+	}
+
+	if err := es.WriteSnapshot(5, map[string]Stock{}); err == nil {
+		t.Fatalf("expected error when seq is ahead of lastSeq")
+	}
+}
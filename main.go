@@ -1,25 +1,67 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"log"
 	"net/http"
+	"os"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
+const eventLogPath = "events.log"
+const snapshotInterval = 5 * time.Minute
+
+// topicWildcard menerima seluruh pesan, terlepas dari topik lainnya.
+const topicWildcard = "*"
+
 type Stock struct {
 	ID       string  `json:"id"`
 	Item     string  `json:"item"`
+	Category string  `json:"category"`
 	Price    float64 `json:"price"`
 	Quantity int     `json:"quantity"` // TAMBAHAN: Field quantity
+	Version  int     `json:"version"`
 }
 
+// ErrStockNotFound menandakan ID stok yang tidak dikenal.
+var ErrStockNotFound = errors.New("stok tidak ditemukan")
+
+// ErrVersionConflict menandakan PUT dikirim dengan Version yang sudah
+// usang, mis. dua admin mengedit stok yang sama secara bersamaan.
+var ErrVersionConflict = errors.New("versi stok tidak cocok")
+
+// ErrInsufficientStock menandakan delta PATCH akan membuat Quantity
+// negatif, mis. permintaan beli lebih banyak dari stok yang tersedia.
+var ErrInsufficientStock = errors.New("stok tidak cukup untuk delta ini")
+
 type WebSocketMessage struct {
 	Type    string `json:"type"`
 	Payload Stock  `json:"payload"`
+	Seq     uint64 `json:"seq,omitempty"`
+}
+
+// ClientMessage adalah pesan kendali yang dikirim klien lewat `/ws`:
+// SUBSCRIBE (opsional last_seq untuk replay, dan/atau topics untuk
+// menyaring pesan mana yang diterima) atau UNSUBSCRIBE.
+type ClientMessage struct {
+	Type    string   `json:"type"`
+	LastSeq uint64   `json:"last_seq,omitempty"`
+	Topics  []string `json:"topics,omitempty"`
+}
+
+// SnapshotMessage adalah balasan pertama hub kepada klien yang meminta
+// replay: keadaan stok lengkap pada saat Seq tersebut.
+type SnapshotMessage struct {
+	Type   string  `json:"type"`
+	Stocks []Stock `json:"stocks"`
+	Seq    uint64  `json:"seq"`
 }
 
 type Store struct {
@@ -33,63 +75,318 @@ func NewStore() *Store {
 	}
 }
 
+// Restore mengganti seluruh isi store, dipakai saat memuat snapshot pada
+// startup.
+func (s *Store) Restore(stocks map[string]Stock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stocks = stocks
+}
+
+// Apply menerapkan satu kejadian dari event log ke store, dipakai untuk
+// replay saat startup.
+func (s *Store) Apply(ev Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ev.Type == "DELETE" {
+		delete(s.stocks, ev.Payload.ID)
+		return
+	}
+	s.stocks[ev.Payload.ID] = ev.Payload
+}
+
+// updateStock mengganti stok dengan ID yang sama, hanya jika stock.Version
+// masih sesuai dengan versi yang tersimpan (concurrency control optimis).
+// Versi yang tersimpan dinaikkan satu pada setiap update yang berhasil.
+func (s *Store) updateStock(stock Stock) (Stock, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.stocks[stock.ID]
+	if !ok {
+		return Stock{}, ErrStockNotFound
+	}
+	if stock.Version != current.Version {
+		return Stock{}, ErrVersionConflict
+	}
+
+	stock.Version = current.Version + 1
+	s.stocks[stock.ID] = stock
+	return stock, nil
+}
+
+// deleteStock membuang stok dengan id dan mengembalikan catatan yang
+// baru saja dihapus, sehingga pemanggil bisa menurunkan topik broadcast
+// (item/category) darinya alih-alih dari body request yang mungkin hanya
+// berisi id.
+func (s *Store) deleteStock(id string) (Stock, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.stocks[id]
+	if !ok {
+		return Stock{}, ErrStockNotFound
+	}
+	delete(s.stocks, id)
+	return current, nil
+}
+
+// createStock menyimpan stok baru, dipakai handleStock POST.
+func (s *Store) createStock(stock Stock) Stock {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stocks[stock.ID] = stock
+	return stock
+}
+
+// applyQuantityDelta menambahkan delta ke Quantity stok secara atomik di
+// bawah kunci store, tanpa memerlukan klien membaca-ubah-tulis sendiri.
+// Delta yang membuat Quantity negatif ditolak alih-alih menghasilkan
+// stok minus.
+func (s *Store) applyQuantityDelta(id string, delta int) (Stock, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.stocks[id]
+	if !ok {
+		return Stock{}, ErrStockNotFound
+	}
+	if current.Quantity+delta < 0 {
+		return Stock{}, ErrInsufficientStock
+	}
+
+	current.Quantity += delta
+	current.Version++
+	s.stocks[id] = current
+	return current, nil
+}
+
+// Snapshot mengembalikan salinan keadaan stok saat ini.
+func (s *Store) Snapshot() map[string]Stock {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	cp := make(map[string]Stock, len(s.stocks))
+	for id, stock := range s.stocks {
+		cp[id] = stock
+	}
+	return cp
+}
+
 type Hub struct {
-	clients   map[*websocket.Conn]bool
-	broadcast chan WebSocketMessage
-	mu        sync.Mutex
+	clients map[*Client]bool
+	store   *Store
+	events  *EventStore
+	broker  Broker
+	mu      sync.Mutex
+
+	// originID menandai kejadian yang dipublikasikan instance ini sendiri
+	// lewat broker, sehingga Hub.run bisa membedakan echo kejadian
+	// sendiri (sudah diterapkan+dicatat oleh mutateAndPublish) dari
+	// kejadian instance lain yang harus diterapkan+dicatat di sini
+	// (lihat mutateAndPublish, persistForeign). Keadaan store saja tidak
+	// cukup untuk membedakan keduanya: dua tulisan beruntun ke id yang
+	// sama bisa membuat store tidak lagi sama dengan payload echo yang
+	// lebih lama walau echo itu tetap milik instance ini.
+	originID string
+
+	// writeMu membungkus setiap "ubah store lalu catat ke event log"
+	// menjadi satu unit atomik terhadap runSnapshotter, agar snapshot
+	// tidak pernah menangkap keadaan store yang sudah berubah tapi
+	// belum tercatat di event log (lihat mutateAndPublish, snapshot).
+	// Publikasi ke broker sengaja dilakukan di luar writeMu: broker.Publish
+	// bisa memblokir (buffer penuh), dan Hub.run — yang harus mengambil
+	// writeMu untuk mencatat kejadian instance lain — adalah satu-satunya
+	// pembaca yang mengosongkan buffer itu. Menahan writeMu sampai
+	// publish selesai akan membuat keduanya saling menunggu.
+	writeMu sync.Mutex
 }
 
-func NewHub() *Hub {
+func NewHub(store *Store, events *EventStore, broker Broker) *Hub {
 	return &Hub{
-		broadcast: make(chan WebSocketMessage),
-		clients:   make(map[*websocket.Conn]bool),
+		clients:  make(map[*Client]bool),
+		store:    store,
+		events:   events,
+		broker:   broker,
+		originID: uuid.New().String(),
 	}
 }
 
-func (h *Hub) run() {
-	for {
-		msg := <-h.broadcast
+// run mendengarkan pesan dari broker dan mengantrekannya ke klien
+// WebSocket lokal yang berlangganan topik terkait; penulisan sungguhan ke
+// setiap koneksi terjadi di write-pump masing-masing klien, sehingga satu
+// klien lambat tidak memblokir yang lain (lihat Client.enqueue).
+func (h *Hub) run(ctx context.Context) {
+	messages, err := h.broker.Subscribe(ctx)
+	if err != nil {
+		log.Fatalf("gagal subscribe ke broker: %v", err)
+	}
+
+	for be := range messages {
+		if be.OriginID != h.originID {
+			h.persistForeign(be.Msg)
+		}
+
+		topics := topicsFor(be.Msg)
 
 		h.mu.Lock()
+		targets := make([]*Client, 0, len(h.clients))
 		for client := range h.clients {
-			if err := client.WriteJSON(msg); err != nil {
-				log.Printf("error writing json: %v", err)
-				client.Close()
-				delete(h.clients, client)
-			}
+			targets = append(targets, client)
 		}
 		h.mu.Unlock()
+
+		// enqueue dipanggil di luar h.mu: pada buffer penuh ia memanggil
+		// h.removeClient, yang mengunci h.mu sendiri. sync.Mutex tidak
+		// reentrant, jadi memanggilnya sambil h.mu masih terkunci akan
+		// membekukan seluruh hub.
+		for _, client := range targets {
+			if client.matches(topics) {
+				client.enqueue(be.Msg)
+			}
+		}
 	}
 }
 
-func (h *Hub) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	var upgrader = websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool { return true },
-	}
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Printf("error upgrading: %v", err)
-		return
+// persistForeign menerapkan dan mencatat sebuah kejadian yang benar-benar
+// berasal dari instance lain (originID berbeda dari milik hub ini),
+// supaya replay (sendSnapshotAndReplay) tetap lengkap pada setup
+// multi-instance (BROKER_URL menunjuk ke JetStream).
+func (h *Hub) persistForeign(msg WebSocketMessage) {
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+
+	h.store.Apply(Event{Type: msg.Type, Payload: msg.Payload})
+	if _, err := h.events.Append(msg.Type, msg.Payload); err != nil {
+		log.Printf("error mencatat kejadian dari broker: %v", err)
 	}
+}
 
+// addClient mendaftarkan klien baru pada hub.
+func (h *Hub) addClient(c *Client) {
 	h.mu.Lock()
-	h.clients[conn] = true
+	h.clients[c] = true
 	h.mu.Unlock()
-	log.Println("Klien baru terhubung")
+}
 
-	defer func() {
-		h.mu.Lock()
-		delete(h.clients, conn)
-		h.mu.Unlock()
-		conn.Close()
-		log.Println("Klien terputus")
-	}()
+// removeClient membuang klien dari hub dan menutup buffer send-nya,
+// memicu writePump untuk keluar. Aman dipanggil lebih dari sekali, dari
+// goroutine manapun (Client.close menjaga penutupan send hanya terjadi
+// sekali).
+func (h *Hub) removeClient(c *Client) {
+	h.mu.Lock()
+	delete(h.clients, c)
+	h.mu.Unlock()
+	c.close()
+}
+
+// topicsFor menurunkan topik-topik sebuah pesan dari payload stoknya.
+func topicsFor(msg WebSocketMessage) []string {
+	return []string{
+		"item:" + msg.Payload.Item,
+		"category:" + msg.Payload.Category,
+	}
+}
 
-	for {
-		if _, _, err := conn.ReadMessage(); err != nil {
-			break
+// matchesTopics melaporkan apakah sekumpulan langganan mencakup salah
+// satu topik, atau wildcard "*".
+func matchesTopics(subscribed map[string]bool, topics []string) bool {
+	if subscribed[topicWildcard] {
+		return true
+	}
+	for _, topic := range topics {
+		if subscribed[topic] {
+			return true
 		}
 	}
+	return false
+}
+
+// mutateAndPublish menjalankan mutate lalu mencatat hasilnya ke event log,
+// keduanya di bawah writeMu yang sama sehingga runSnapshotter tidak
+// pernah melihat keadaan store yang sudah berubah tapi belum tercatat di
+// event log (atau sebaliknya, lihat snapshot). Publikasi ke broker
+// sengaja terjadi setelah writeMu dilepas — lihat catatan pada writeMu.
+func (h *Hub) mutateAndPublish(msgType string, mutate func() (Stock, error)) (Stock, error) {
+	h.writeMu.Lock()
+	stock, err := mutate()
+	if err != nil {
+		h.writeMu.Unlock()
+		return Stock{}, err
+	}
+	ev, appendErr := h.events.Append(msgType, stock)
+	h.writeMu.Unlock()
+	if appendErr != nil {
+		log.Printf("error menulis event log: %v", appendErr)
+		return stock, nil
+	}
+
+	msg := WebSocketMessage{Type: msgType, Payload: stock, Seq: ev.Seq}
+	if err := h.broker.Publish(BrokerEvent{OriginID: h.originID, Msg: msg}); err != nil {
+		log.Printf("error publish ke broker: %v", err)
+	}
+	return stock, nil
+}
+
+// snapshot menangkap keadaan store dan lastSeq event log sebagai satu
+// pasangan yang konsisten, dengan mengunci writeMu yang sama dipakai
+// mutateAndPublish agar tidak terjadi antara keduanya.
+func (h *Hub) snapshot() (map[string]Stock, uint64) {
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+	return h.store.Snapshot(), h.events.LastSeq()
+}
+
+// sendSnapshotAndReplay mengantre keadaan stok saat ini lalu memutar
+// ulang setiap kejadian dengan seq > lastSeq ke buffer send klien,
+// sebelum klien menerima pesan broadcast langsung.
+func (h *Hub) sendSnapshotAndReplay(c *Client, lastSeq uint64) error {
+	stocks, seq := h.snapshot()
+	c.enqueue(SnapshotMessage{
+		Type:   "SNAPSHOT",
+		Stocks: mapToSlice(stocks),
+		Seq:    seq,
+	})
+
+	return h.events.Replay(lastSeq, func(ev Event) error {
+		c.enqueue(WebSocketMessage{Type: ev.Type, Payload: ev.Payload, Seq: ev.Seq})
+		return nil
+	})
+}
+
+func mapToSlice(stocks map[string]Stock) []Stock {
+	list := make([]Stock, 0, len(stocks))
+	for _, stock := range stocks {
+		list = append(list, stock)
+	}
+	return list
+}
+
+// wsUpgrader mengaktifkan kompresi per-pesan dan menawarkan
+// binaryProtocol sebagai subprotokol opsional untuk viewer mobile yang
+// sensitif terhadap bandwidth.
+var wsUpgrader = websocket.Upgrader{
+	CheckOrigin:       func(r *http.Request) bool { return true },
+	Subprotocols:      []string{binaryProtocol},
+	EnableCompression: true,
+}
+
+func (h *Hub) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("error upgrading: %v", err)
+		return
+	}
+	conn.EnableWriteCompression(true)
+
+	subject := claimsFromContext(r.Context()).Subject
+	binary := conn.Subprotocol() == binaryProtocol
+
+	client := newClient(h, conn, subject, binary)
+	h.addClient(client)
+	log.Printf("Klien baru terhubung: %s (biner: %v)", subject, binary)
+
+	go client.writePump()
+	client.readPump()
 }
 
 func (s *Store) handleGetStocks(w http.ResponseWriter, r *http.Request) {
@@ -105,57 +402,110 @@ func (s *Store) handleGetStocks(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(stockList)
 }
 
+// quantityDeltaRequest adalah body PATCH /api/stock: perubahan Quantity
+// yang diterapkan atomik, memodelkan alur "beli N unit" dengan benar
+// alih-alih memaksa klien membaca-ubah-tulis.
+type quantityDeltaRequest struct {
+	ID            string `json:"id"`
+	QuantityDelta int    `json:"quantity_delta"`
+}
+
 func (s *Store) handleStock(hub *Hub, w http.ResponseWriter, r *http.Request) {
-	var stock Stock
-	if err := json.NewDecoder(r.Body).Decode(&stock); err != nil {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
 		http.Error(w, "Request body tidak valid", http.StatusBadRequest)
 		return
 	}
 
-	var msgType string
-
 	switch r.Method {
 	case "POST":
+		var stock Stock
+		if err := json.Unmarshal(body, &stock); err != nil {
+			http.Error(w, "Request body tidak valid", http.StatusBadRequest)
+			return
+		}
 		stock.ID = uuid.New().String()
-		s.mu.Lock()
-		s.stocks[stock.ID] = stock
-		s.mu.Unlock()
-		msgType = "CREATE"
-		log.Printf("Stok DIBUAT: %s (Qty: %d)", stock.Item, stock.Quantity)
+		stock.Version = 1
+		created, _ := hub.mutateAndPublish("CREATE", func() (Stock, error) {
+			return s.createStock(stock), nil
+		})
+		log.Printf("Stok DIBUAT: %s (Qty: %d)", created.Item, created.Quantity)
+		writeStock(w, created)
 
 	case "PUT":
+		var stock Stock
+		if err := json.Unmarshal(body, &stock); err != nil {
+			http.Error(w, "Request body tidak valid", http.StatusBadRequest)
+			return
+		}
 		if stock.ID == "" {
 			http.Error(w, "ID diperlukan untuk update", http.StatusBadRequest)
 			return
 		}
-		s.mu.Lock()
-		s.stocks[stock.ID] = stock
-		s.mu.Unlock()
-		msgType = "UPDATE"
-		log.Printf("Stok DIPERBARUI: %s (Qty: %d)", stock.Item, stock.Quantity)
+		updated, err := hub.mutateAndPublish("UPDATE", func() (Stock, error) {
+			return s.updateStock(stock)
+		})
+		if err != nil {
+			if errors.Is(err, ErrVersionConflict) {
+				http.Error(w, "versi stok sudah usang, muat ulang lalu coba lagi", http.StatusConflict)
+				return
+			}
+			http.Error(w, "stok tidak ditemukan", http.StatusNotFound)
+			return
+		}
+		log.Printf("Stok DIPERBARUI: %s (Qty: %d)", updated.Item, updated.Quantity)
+		writeStock(w, updated)
+
+	case "PATCH":
+		var req quantityDeltaRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, "Request body tidak valid", http.StatusBadRequest)
+			return
+		}
+		if req.ID == "" {
+			http.Error(w, "ID diperlukan untuk delta", http.StatusBadRequest)
+			return
+		}
+		updated, err := hub.mutateAndPublish("UPDATE", func() (Stock, error) {
+			return s.applyQuantityDelta(req.ID, req.QuantityDelta)
+		})
+		if err != nil {
+			if errors.Is(err, ErrInsufficientStock) {
+				http.Error(w, "stok tidak cukup untuk delta ini", http.StatusConflict)
+				return
+			}
+			http.Error(w, "stok tidak ditemukan", http.StatusNotFound)
+			return
+		}
+		log.Printf("Stok DIUBAH: %s (delta: %d, qty baru: %d)", updated.Item, req.QuantityDelta, updated.Quantity)
+		writeStock(w, updated)
 
 	case "DELETE":
+		var stock Stock
+		if err := json.Unmarshal(body, &stock); err != nil {
+			http.Error(w, "Request body tidak valid", http.StatusBadRequest)
+			return
+		}
 		if stock.ID == "" {
 			http.Error(w, "ID diperlukan untuk delete", http.StatusBadRequest)
 			return
 		}
-		s.mu.Lock()
-		delete(s.stocks, stock.ID)
-		s.mu.Unlock()
-		msgType = "DELETE"
-		log.Printf("Stok DIHAPUS: %s", stock.Item)
+		deleted, err := hub.mutateAndPublish("DELETE", func() (Stock, error) {
+			return s.deleteStock(stock.ID)
+		})
+		if err != nil {
+			http.Error(w, "stok tidak ditemukan", http.StatusNotFound)
+			return
+		}
+		log.Printf("Stok DIHAPUS: %s", deleted.Item)
+		writeStock(w, deleted)
 
 	default:
 		http.Error(w, "Metode tidak diizinkan", http.StatusMethodNotAllowed)
-		return
 	}
+}
 
-	msg := WebSocketMessage{
-		Type:    msgType,
-		Payload: stock,
-	}
-	hub.broadcast <- msg
-
+func writeStock(w http.ResponseWriter, stock Stock) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(stock)
 }
@@ -169,19 +519,49 @@ func serveViewerPage(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
-	hub := NewHub()
-	go hub.run()
+	events, err := NewEventStore(eventLogPath)
+	if err != nil {
+		log.Fatalf("gagal membuka event log: %v", err)
+	}
 
 	store := NewStore()
+	if seq, stocks, err := events.LoadSnapshot(); err != nil {
+		log.Fatalf("gagal memuat snapshot: %v", err)
+	} else {
+		if stocks != nil {
+			store.Restore(stocks)
+		}
+		if err := events.Replay(seq, func(ev Event) error {
+			store.Apply(ev)
+			return nil
+		}); err != nil {
+			log.Fatalf("gagal memutar ulang event log: %v", err)
+		}
+	}
+
+	broker, err := NewBrokerFromEnv()
+	if err != nil {
+		log.Fatalf("gagal menyiapkan broker: %v", err)
+	}
+
+	hub := NewHub(store, events, broker)
+	go hub.run(context.Background())
+	go runSnapshotter(hub)
+
+	auth, err := NewAuth([]byte(os.Getenv("JWT_SECRET")))
+	if err != nil {
+		log.Fatalf("gagal menyiapkan auth: %v", err)
+	}
 
 	http.HandleFunc("/", serveViewerPage)
 	http.HandleFunc("/admin", serveAdminPage)
-	http.HandleFunc("/ws", hub.handleWebSocket)
-	http.HandleFunc("/api/stocks", store.handleGetStocks)
+	http.HandleFunc("/api/login", auth.handleLogin)
+	http.HandleFunc("/ws", auth.Middleware(hub.handleWebSocket))
+	http.HandleFunc("/api/stocks", auth.Middleware(store.handleGetStocks))
 
-	http.HandleFunc("/api/stock", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/api/stock", auth.RequireAdmin(func(w http.ResponseWriter, r *http.Request) {
 		store.handleStock(hub, w, r)
-	})
+	}))
 
 	log.Println("Server dimulai di http://localhost:8080")
 	log.Println("Halaman Admin: http://localhost:8080/admin")
@@ -191,3 +571,19 @@ func main() {
 		log.Fatal("ListenAndServe: ", err)
 	}
 }
+
+// runSnapshotter menuliskan snapshot keadaan stok secara berkala agar
+// replay pada koneksi baru tidak perlu memutar ulang seluruh riwayat.
+// hub.snapshot menangkap stocks dan seq sebagai pasangan yang konsisten,
+// sehingga snapshot tidak pernah lebih baru dari log yang menyertainya.
+func runSnapshotter(hub *Hub) {
+	ticker := time.NewTicker(snapshotInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		stocks, seq := hub.snapshot()
+		if err := hub.events.WriteSnapshot(seq, stocks); err != nil {
+			log.Printf("error menyimpan snapshot: %v", err)
+		}
+	}
+}
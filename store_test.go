@@ -0,0 +1,91 @@
+package main
+
+import "testing"
+
+func TestStoreUpdateStockVersionConflict(t *testing.T) {
+	s := NewStore()
+	s.Restore(map[string]Stock{
+		"1": {ID: "1", Item: "Kopi", Quantity: 10, Version: 1},
+	})
+
+	if _, err := s.updateStock(Stock{ID: "1", Item: "Kopi", Quantity: 5, Version: 2}); err != ErrVersionConflict {
+		t.Fatalf("expected ErrVersionConflict, got %v", err)
+	}
+
+	updated, err := s.updateStock(Stock{ID: "1", Item: "Kopi", Quantity: 5, Version: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if updated.Version != 2 {
+		t.Fatalf("expected version to advance to 2, got %d", updated.Version)
+	}
+}
+
+func TestStoreDeleteStockReturnsDeletedRecord(t *testing.T) {
+	s := NewStore()
+	s.Restore(map[string]Stock{
+		"1": {ID: "1", Item: "Kopi", Category: "Minuman"},
+	})
+
+	deleted, err := s.deleteStock("1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if deleted.Item != "Kopi" || deleted.Category != "Minuman" {
+		t.Fatalf("expected deleted record to carry Item/Category, got %+v", deleted)
+	}
+
+	if _, err := s.deleteStock("1"); err != ErrStockNotFound {
+		t.Fatalf("expected ErrStockNotFound on second delete, got %v", err)
+	}
+}
+
+func TestStoreApplyIsIdempotent(t *testing.T) {
+	s := NewStore()
+	stock := Stock{ID: "1", Item: "Kopi", Quantity: 10, Version: 1}
+
+	s.Apply(Event{Type: "UPDATE", Payload: stock})
+	s.Apply(Event{Type: "UPDATE", Payload: stock})
+	if got := s.Snapshot()["1"]; got != stock {
+		t.Fatalf("expected re-applying the same event to be a no-op, got %+v", got)
+	}
+
+	s.Apply(Event{Type: "DELETE", Payload: Stock{ID: "1"}})
+	s.Apply(Event{Type: "DELETE", Payload: Stock{ID: "1"}})
+	if _, ok := s.Snapshot()["1"]; ok {
+		t.Fatalf("expected record to be gone after DELETE applied twice")
+	}
+}
+
+func TestApplyQuantityDeltaRejectsOversell(t *testing.T) {
+	s := NewStore()
+	s.Restore(map[string]Stock{
+		"1": {ID: "1", Item: "Kopi", Quantity: 5},
+	})
+
+	if _, err := s.applyQuantityDelta("1", -6); err != ErrInsufficientStock {
+		t.Fatalf("expected ErrInsufficientStock, got %v", err)
+	}
+
+	updated, err := s.applyQuantityDelta("1", -5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if updated.Quantity != 0 {
+		t.Fatalf("expected quantity to land exactly at 0, got %d", updated.Quantity)
+	}
+}
+
+func TestMatchesTopics(t *testing.T) {
+	subs := map[string]bool{"item:Kopi": true}
+
+	if !matchesTopics(subs, []string{"item:Kopi", "category:Minuman"}) {
+		t.Fatalf("expected match on item:Kopi")
+	}
+	if matchesTopics(subs, []string{"item:Teh", "category:Minuman"}) {
+		t.Fatalf("expected no match on unrelated topics")
+	}
+	if !matchesTopics(map[string]bool{topicWildcard: true}, []string{"item:Teh"}) {
+		t.Fatalf("expected wildcard subscription to match anything")
+	}
+}